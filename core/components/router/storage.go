@@ -4,12 +4,13 @@
 package router
 
 import (
+	"fmt"
+	"net/url"
 	"sync"
 	"time"
 
 	. "github.com/TheThingsNetwork/ttn/core"
 	"github.com/TheThingsNetwork/ttn/utils/errors"
-	"github.com/TheThingsNetwork/ttn/utils/readwriter"
 	dbutil "github.com/TheThingsNetwork/ttn/utils/storage"
 	"github.com/brocaar/lorawan"
 )
@@ -21,35 +22,132 @@ type Storage interface {
 	Close() error
 }
 
-type entry struct {
-	Recipient []byte
-	until     time.Time
+// migrator is implemented by backends that can enumerate and rewrite their
+// own entries, which MigrateEntries needs to change their codec in place.
+type migrator interface {
+	migrate(to Codec) error
 }
 
-type storage struct {
+// MigrateEntries rewrites every entry held by s to use the given codec,
+// under whatever write lock the backend provides. It returns an error for
+// backends that don't support enumeration rather than silently doing
+// nothing.
+func MigrateEntries(s Storage, to Codec) error {
+	m, ok := s.(migrator)
+	if !ok {
+		return errors.New(errors.Operational, "Storage backend cannot be migrated")
+	}
+	return m.migrate(to)
+}
+
+// StorageOptions configures a router Storage backend, independent of which
+// driver eventually serves it.
+type StorageOptions struct {
+	// Name is the bucket / table / key-prefix entries are stored under.
+	Name string
+
+	// ExpiryDelay is how long an entry remains valid after being stored.
+	// Drivers that support native TTLs (etcd, Redis) use it to set the TTL
+	// directly; the embedded driver still checks it on Lookup.
+	ExpiryDelay time.Duration
+
+	// Compression trades CPU for disk space on every entry written: "none"
+	// (the default), "gzip" or "zstd". See storageCodec.go.
+	Compression string
+}
+
+// StorageFactory builds a Storage from a parsed DSN and a set of options.
+// The host/path/query of dsn are driver-specific; only the scheme is used to
+// pick the driver.
+type StorageFactory func(dsn *url.URL, opts StorageOptions) (Storage, error)
+
+var (
+	backendsMu sync.Mutex
+	backends   = make(map[string]StorageFactory)
+)
+
+// RegisterStorageBackend makes a Storage driver available under the given
+// DSN scheme (e.g. "etcd", "redis"). It is meant to be called from the
+// driver's own init(), mirroring how transports and brokers register
+// themselves in go-micro. Registering twice under the same name panics, as
+// it almost always indicates two drivers were linked in by mistake.
+func RegisterStorageBackend(name string, factory StorageFactory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	if _, ok := backends[name]; ok {
+		panic(fmt.Sprintf("router: storage backend %q already registered", name))
+	}
+	backends[name] = factory
+}
+
+// NewStorage creates a new storage for the router. dsn picks the backend
+// through its scheme: a bare name (no "://") keeps the historical behavior
+// of opening an embedded database file, while "etcd://host:2379/bucket" or
+// "redis://host:6379/bucket" dial the matching clustered backend.
+func NewStorage(dsn string, opts StorageOptions) (Storage, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || u.Scheme == "" {
+		u = &url.URL{Scheme: "local", Opaque: dsn}
+	}
+
+	if opts.Name == "" {
+		opts.Name = "broker"
+	}
+
+	backendsMu.Lock()
+	factory, ok := backends[u.Scheme]
+	backendsMu.Unlock()
+	if !ok {
+		return nil, errors.New(errors.Operational, fmt.Sprintf("Unknown storage backend %q", u.Scheme))
+	}
+
+	return factory(u, opts)
+}
+
+func init() {
+	RegisterStorageBackend("local", newLocalStorage)
+}
+
+// localStorage is the historical embedded-database backend. It is the only
+// driver that needs to check ExpiryDelay itself on Lookup: the clustered
+// drivers (etcd, Redis) let the store expire the key for them.
+type localStorage struct {
 	sync.Mutex
 	db          dbutil.Interface
 	Name        string
 	ExpiryDelay time.Duration
+	codec       Codec
 }
 
-// NewStorage creates a new internal storage for the router
-func NewStorage(name string, delay time.Duration) (Storage, error) {
+// newLocalStorage implements the StorageFactory signature for the "local"
+// (and bare-name) scheme; u.Opaque carries the historical db file name.
+func newLocalStorage(u *url.URL, opts StorageOptions) (Storage, error) {
+	name := u.Opaque
+	if name == "" {
+		name = u.Host + u.Path
+	}
+
+	codec, err := parseCodec(opts.Compression)
+	if err != nil {
+		return nil, err
+	}
+
 	itf, err := dbutil.New(name)
 	if err != nil {
 		return nil, errors.New(errors.Operational, err)
 	}
 
-	return &storage{db: itf, ExpiryDelay: delay, Name: "broker"}, nil
+	return &localStorage{db: itf, ExpiryDelay: opts.ExpiryDelay, Name: opts.Name, codec: codec}, nil
 }
 
 // Lookup implements the router.Storage interface
-func (s *storage) Lookup(devEUI lorawan.EUI64) (entry, error) {
+func (s *localStorage) Lookup(devEUI lorawan.EUI64) (entry, error) {
 	return s.lookup(devEUI, true)
 }
 
 // lookup offers an indirection in order to avoid taking a lock if not needed
-func (s *storage) lookup(devEUI lorawan.EUI64, lock bool) (entry, error) {
+func (s *localStorage) lookup(devEUI lorawan.EUI64, lock bool) (entry, error) {
 	// NOTE This works under the assumption that a read or write lock is already held by
 	// the callee (e.g. Store()
 	if lock {
@@ -83,7 +181,7 @@ func (s *storage) lookup(devEUI lorawan.EUI64, lock bool) (entry, error) {
 }
 
 // Store implements the router.Storage interface
-func (s *storage) Store(reg RRegistration) error {
+func (s *localStorage) Store(reg RRegistration) error {
 	devEUI := reg.DevEUI()
 	recipient, err := reg.Recipient().MarshalBinary()
 	if err != nil {
@@ -104,34 +202,19 @@ func (s *storage) Store(reg RRegistration) error {
 	return s.db.Store(s.Name, devEUI[:], []dbutil.Entry{&entry{
 		Recipient: recipient,
 		until:     time.Now().Add(s.ExpiryDelay),
+		codec:     s.codec,
 	}})
 
 }
 
 // Close implements the router.Storage interface
-func (s *storage) Close() error {
+func (s *localStorage) Close() error {
 	return s.db.Close()
 }
 
-// MarshalBinary implements the encoding.BinaryMarshaler interface
-func (e entry) MarshalBinary() ([]byte, error) {
-	data, err := e.until.MarshalBinary()
-	if err != nil {
-		return nil, errors.New(errors.Structural, err)
-	}
-
-	rw := readwriter.New(nil)
-	rw.Write(e.Recipient)
-	rw.Write(data)
-	return rw.Bytes()
-}
-
-// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface
-func (e *entry) UnmarshalBinary(data []byte) error {
-	rw := readwriter.New(data)
-	rw.Read(func(data []byte) { e.Recipient = data })
-	rw.TryRead(func(data []byte) error {
-		return e.until.UnmarshalBinary(data)
-	})
-	return rw.Err()
-}
+// localStorage intentionally does not implement the migrator interface:
+// dbutil.Interface only gives us Lookup/Store/Flush/Close, none of which can
+// enumerate existing keys, so there's no way to rewrite every entry's codec
+// in place without adding enumeration support to that package first.
+// MigrateEntries already handles this gracefully, returning an Operational
+// error for any backend that can't be migrated.
@@ -0,0 +1,149 @@
+// Copyright © 2016 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/TheThingsNetwork/ttn/utils/errors"
+	"github.com/TheThingsNetwork/ttn/utils/readwriter"
+	"github.com/klauspost/compress/zstd"
+)
+
+// entryVersion tags the on-disk envelope introduced alongside compression.
+// UnmarshalBinary falls back to the pre-versioning reader whenever the
+// first byte isn't this value, so existing un-tagged entries keep working.
+const entryVersion = 1
+
+// Codec identifies how an entry's payload is compressed on disk.
+type Codec byte
+
+const (
+	// CodecRaw stores the payload as-is.
+	CodecRaw Codec = iota
+	// CodecGzip compresses the payload with gzip.
+	CodecGzip
+	// CodecZstd compresses the payload with zstd.
+	CodecZstd
+)
+
+// parseCodec turns a StorageOptions.Compression value into a Codec.
+func parseCodec(compression string) (Codec, error) {
+	switch compression {
+	case "", "none":
+		return CodecRaw, nil
+	case "gzip":
+		return CodecGzip, nil
+	case "zstd":
+		return CodecZstd, nil
+	default:
+		return 0, errors.New(errors.Structural, fmt.Sprintf("Unknown compression %q", compression))
+	}
+}
+
+type entry struct {
+	Recipient []byte
+	until     time.Time
+	codec     Codec
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface. The
+// on-disk layout is a one-byte version, a one-byte codec tag, then the
+// (possibly compressed) Recipient||until payload.
+func (e entry) MarshalBinary() ([]byte, error) {
+	until, err := e.until.MarshalBinary()
+	if err != nil {
+		return nil, errors.New(errors.Structural, err)
+	}
+
+	rw := readwriter.New(nil)
+	rw.Write(e.Recipient)
+	rw.Write(until)
+	payload, err := rw.Bytes()
+	if err != nil {
+		return nil, errors.New(errors.Structural, err)
+	}
+
+	compressed, err := compress(e.codec, payload)
+	if err != nil {
+		return nil, errors.New(errors.Structural, err)
+	}
+
+	return append([]byte{entryVersion, byte(e.codec)}, compressed...), nil
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface
+func (e *entry) UnmarshalBinary(data []byte) error {
+	if len(data) < 2 || data[0] != entryVersion {
+		// Pre-versioning entry: no tags, raw Recipient||until payload.
+		e.codec = CodecRaw
+		return e.unmarshalPayload(data)
+	}
+
+	e.codec = Codec(data[1])
+	payload, err := decompress(e.codec, data[2:])
+	if err != nil {
+		return errors.New(errors.Structural, err)
+	}
+	return e.unmarshalPayload(payload)
+}
+
+func (e *entry) unmarshalPayload(data []byte) error {
+	rw := readwriter.New(data)
+	rw.Read(func(data []byte) { e.Recipient = data })
+	rw.TryRead(func(data []byte) error {
+		return e.until.UnmarshalBinary(data)
+	})
+	return rw.Err()
+}
+
+// compress encodes payload according to codec. CodecRaw returns it as-is.
+func compress(codec Codec, payload []byte) ([]byte, error) {
+	switch codec {
+	case CodecGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(payload); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CodecZstd:
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, err
+		}
+		return enc.EncodeAll(payload, nil), nil
+	default:
+		return payload, nil
+	}
+}
+
+// decompress reverses compress.
+func decompress(codec Codec, payload []byte) ([]byte, error) {
+	switch codec {
+	case CodecGzip:
+		r, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	case CodecZstd:
+		dec, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer dec.Close()
+		return dec.DecodeAll(payload, nil)
+	default:
+		return payload, nil
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright © 2016 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/TheThingsNetwork/ttn/utils/errors"
+	"github.com/brocaar/lorawan"
+	etcd "github.com/coreos/etcd/clientv3"
+)
+
+func init() {
+	RegisterStorageBackend("etcd", newEtcdStorage)
+}
+
+// etcdStorage stores entries as etcd keys, relying on etcd's native lease
+// TTL instead of checking ExpiryDelay after the fact.
+type etcdStorage struct {
+	client *etcd.Client
+	prefix string
+	ttl    time.Duration
+	codec  Codec
+}
+
+// newEtcdStorage implements the StorageFactory signature for the "etcd"
+// scheme, e.g. etcd://host1:2379,host2:2379/ttn-router.
+func newEtcdStorage(u *url.URL, opts StorageOptions) (Storage, error) {
+	codec, err := parseCodec(opts.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := strings.Split(u.Host, ",")
+	client, err := etcd.New(etcd.Config{
+		Endpoints:   endpoints,
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		return nil, errors.New(errors.Operational, err)
+	}
+
+	return &etcdStorage{client: client, prefix: "/" + opts.Name + strings.TrimRight(u.Path, "/") + "/", ttl: opts.ExpiryDelay, codec: codec}, nil
+}
+
+func (s *etcdStorage) key(devEUI lorawan.EUI64) string {
+	return s.prefix + devEUI.String()
+}
+
+// Lookup implements the router.Storage interface
+func (s *etcdStorage) Lookup(devEUI lorawan.EUI64) (entry, error) {
+	resp, err := s.client.Get(context.Background(), s.key(devEUI))
+	if err != nil {
+		return entry{}, errors.New(errors.Operational, err)
+	}
+	if len(resp.Kvs) == 0 {
+		return entry{}, errors.New(errors.NotFound, "Not Found")
+	}
+
+	var e entry
+	if err := e.UnmarshalBinary(resp.Kvs[0].Value); err != nil {
+		return entry{}, errors.New(errors.Structural, err)
+	}
+	return e, nil
+}
+
+// Store implements the router.Storage interface. The create is a single
+// compare-and-swap transaction, atomic against other router instances
+// racing to register the same DevEUI against the same etcd cluster: a
+// plain Lookup-then-Put can let two of them both pass the not-found check
+// and silently overwrite one another.
+func (s *etcdStorage) Store(reg RRegistration) error {
+	devEUI := reg.DevEUI()
+	recipient, err := reg.Recipient().MarshalBinary()
+	if err != nil {
+		return errors.New(errors.Structural, err)
+	}
+
+	e := entry{Recipient: recipient, until: time.Now().Add(s.ttl), codec: s.codec}
+	data, err := e.MarshalBinary()
+	if err != nil {
+		return errors.New(errors.Structural, err)
+	}
+
+	// A zero TTL means "never expires", the same convention
+	// localStorage.lookup and storage_redis.go's SetNX(..., 0) follow. An etcd
+	// lease with TTL 0 would mean the opposite, so skip leasing entirely; a
+	// negative TTL is stored without a lease as well.
+	var putOpts []etcd.OpOption
+	if s.ttl > 0 {
+		lease, err := s.client.Grant(context.Background(), int64(s.ttl.Seconds()))
+		if err != nil {
+			return errors.New(errors.Operational, err)
+		}
+		putOpts = append(putOpts, etcd.WithLease(lease.ID))
+	}
+
+	key := s.key(devEUI)
+	resp, err := s.client.Txn(context.Background()).
+		If(etcd.Compare(etcd.CreateRevision(key), "=", 0)).
+		Then(etcd.OpPut(key, string(data), putOpts...)).
+		Commit()
+	if err != nil {
+		return errors.New(errors.Operational, err)
+	}
+	if !resp.Succeeded {
+		return errors.New(errors.Structural, "Already exists")
+	}
+	return nil
+}
+
+// Close implements the router.Storage interface
+func (s *etcdStorage) Close() error {
+	return s.client.Close()
+}
+
+// migrate implements the migrator interface, rewriting every key under the
+// storage's prefix to use the given codec while keeping its lease alive.
+func (s *etcdStorage) migrate(to Codec) error {
+	resp, err := s.client.Get(context.Background(), s.prefix, etcd.WithPrefix())
+	if err != nil {
+		return errors.New(errors.Operational, err)
+	}
+
+	for _, kv := range resp.Kvs {
+		var e entry
+		if err := e.UnmarshalBinary(kv.Value); err != nil {
+			return errors.New(errors.Structural, err)
+		}
+		if e.codec == to {
+			continue
+		}
+		e.codec = to
+
+		data, err := e.MarshalBinary()
+		if err != nil {
+			return errors.New(errors.Structural, err)
+		}
+
+		var putErr error
+		if kv.Lease == 0 {
+			_, putErr = s.client.Put(context.Background(), string(kv.Key), string(data))
+		} else {
+			_, putErr = s.client.Put(context.Background(), string(kv.Key), string(data), etcd.WithLease(etcd.LeaseID(kv.Lease)))
+		}
+		if putErr != nil {
+			return errors.New(errors.Operational, putErr)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+// Copyright © 2016 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/TheThingsNetwork/ttn/utils/errors"
+	"github.com/brocaar/lorawan"
+	redis "gopkg.in/redis.v5"
+)
+
+func init() {
+	RegisterStorageBackend("redis", newRedisStorage)
+}
+
+// redisStorage stores entries as redis keys with a native EXPIRE, instead of
+// checking ExpiryDelay after the fact.
+type redisStorage struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+	codec  Codec
+}
+
+// newRedisStorage implements the StorageFactory signature for the "redis"
+// scheme, e.g. redis://host:6379/ttn-router.
+func newRedisStorage(u *url.URL, opts StorageOptions) (Storage, error) {
+	codec, err := parseCodec(opts.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: u.Host})
+	if err := client.Ping().Err(); err != nil {
+		return nil, errors.New(errors.Operational, err)
+	}
+
+	return &redisStorage{client: client, prefix: opts.Name + strings.TrimRight(u.Path, "/") + ":", ttl: opts.ExpiryDelay, codec: codec}, nil
+}
+
+func (s *redisStorage) key(devEUI lorawan.EUI64) string {
+	return s.prefix + devEUI.String()
+}
+
+// Lookup implements the router.Storage interface
+func (s *redisStorage) Lookup(devEUI lorawan.EUI64) (entry, error) {
+	data, err := s.client.Get(s.key(devEUI)).Bytes()
+	if err == redis.Nil {
+		return entry{}, errors.New(errors.NotFound, "Not Found")
+	} else if err != nil {
+		return entry{}, errors.New(errors.Operational, err)
+	}
+
+	var e entry
+	if err := e.UnmarshalBinary(data); err != nil {
+		return entry{}, errors.New(errors.Structural, err)
+	}
+	return e, nil
+}
+
+// Store implements the router.Storage interface. The create uses SetNX
+// rather than a Lookup-then-Set, so it stays atomic against other router
+// instances racing to register the same DevEUI against the same redis
+// instance: a plain Lookup-then-Set can let two of them both pass the
+// not-found check and silently overwrite one another.
+func (s *redisStorage) Store(reg RRegistration) error {
+	devEUI := reg.DevEUI()
+	recipient, err := reg.Recipient().MarshalBinary()
+	if err != nil {
+		return errors.New(errors.Structural, err)
+	}
+
+	e := entry{Recipient: recipient, until: time.Now().Add(s.ttl), codec: s.codec}
+	data, err := e.MarshalBinary()
+	if err != nil {
+		return errors.New(errors.Structural, err)
+	}
+
+	set, err := s.client.SetNX(s.key(devEUI), data, s.ttl).Result()
+	if err != nil {
+		return errors.New(errors.Operational, err)
+	}
+	if !set {
+		return errors.New(errors.Structural, "Already exists")
+	}
+	return nil
+}
+
+// Close implements the router.Storage interface
+func (s *redisStorage) Close() error {
+	return s.client.Close()
+}
+
+// migrate implements the migrator interface, rewriting every key under the
+// storage's prefix to use the given codec while preserving its remaining
+// TTL.
+func (s *redisStorage) migrate(to Codec) error {
+	iter := s.client.Scan(0, s.prefix+"*", 0).Iterator()
+	for iter.Next() {
+		key := iter.Val()
+
+		data, err := s.client.Get(key).Bytes()
+		if err != nil {
+			return errors.New(errors.Operational, err)
+		}
+
+		var e entry
+		if err := e.UnmarshalBinary(data); err != nil {
+			return errors.New(errors.Structural, err)
+		}
+		if e.codec == to {
+			continue
+		}
+		e.codec = to
+
+		ttl := s.client.TTL(key).Val()
+		encoded, err := e.MarshalBinary()
+		if err != nil {
+			return errors.New(errors.Structural, err)
+		}
+		if err := s.client.Set(key, encoded, ttl).Err(); err != nil {
+			return errors.New(errors.Operational, err)
+		}
+	}
+	return iter.Err()
+}
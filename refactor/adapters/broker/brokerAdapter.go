@@ -0,0 +1,252 @@
+// Copyright © 2016 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package broker
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	core "github.com/TheThingsNetwork/ttn/refactor"
+	"github.com/TheThingsNetwork/ttn/utils/errors"
+	"github.com/TheThingsNetwork/ttn/utils/log"
+	"github.com/TheThingsNetwork/ttn/utils/readwriter"
+	"github.com/brocaar/lorawan"
+)
+
+// Driver abstracts the pub/sub system the adapter rides on (NATS, MQTT,
+// Kafka, ...), so the adapter itself never depends on a specific broker.
+type Driver interface {
+	Publish(topic string, data []byte) error
+	Subscribe(topic string, handler func(data []byte)) error
+	Close() error
+}
+
+// Factory builds a Driver from a DSN, e.g. nats://localhost:4222.
+type Factory func(dsn *url.URL) (Driver, error)
+
+var (
+	driversMu sync.Mutex
+	drivers   = make(map[string]Factory)
+)
+
+// Register makes a broker driver available under the given DSN scheme. It
+// is meant to be called from the driver's own init(), the same pattern used
+// by router.RegisterStorageBackend.
+func Register(name string, factory Factory) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+
+	if _, ok := drivers[name]; ok {
+		panic(fmt.Sprintf("broker adapter: driver %q already registered", name))
+	}
+	drivers[name] = factory
+}
+
+// Options configures the topic layout the adapter publishes to and
+// subscribes on.
+type Options struct {
+	// UpTopicTemplate is the topic Send() publishes to, with "{devEUI}"
+	// replaced by the packet's DevEUI. Defaults to "devices/{devEUI}/up".
+	UpTopicTemplate string
+
+	// RegisterTopic is subscribed to for inbound registrations, so that
+	// application servers publishing on it automatically create entries in
+	// the router Storage. Defaults to "devices/+/register".
+	RegisterTopic string
+}
+
+func (o Options) upTopic(devEUI lorawan.EUI64) string {
+	tpl := o.UpTopicTemplate
+	if tpl == "" {
+		tpl = "devices/{devEUI}/up"
+	}
+	return strings.Replace(tpl, "{devEUI}", devEUI.String(), 1)
+}
+
+func (o Options) registerTopic() string {
+	if o.RegisterTopic == "" {
+		return "devices/+/register"
+	}
+	return o.RegisterTopic
+}
+
+// brokerRecipient materializes recipients manipulated by the broker
+// adapter: simply the topic to publish to.
+type brokerRecipient struct {
+	topic string
+}
+
+// Topic returns the topic this recipient publishes to.
+func (r brokerRecipient) Topic() string { return r.topic }
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface
+func (r brokerRecipient) MarshalBinary() ([]byte, error) {
+	rw := readwriter.New(nil)
+	rw.Write(r.topic)
+	return rw.Bytes()
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface
+func (r *brokerRecipient) UnmarshalBinary(data []byte) error {
+	rw := readwriter.New(data)
+	rw.Read(func(data []byte) { r.topic = string(data) })
+	return rw.Err()
+}
+
+// brokerRegistration couples a recipient with the device it registered for
+type brokerRegistration struct {
+	recipient brokerRecipient
+	devEUI    lorawan.EUI64
+}
+
+// Recipient implements the core.Registration interface
+func (r brokerRegistration) Recipient() core.Recipient { return r.recipient }
+
+// DevEUI implements the core.Registration interface
+func (r brokerRegistration) DevEUI() (lorawan.EUI64, error) { return r.devEUI, nil }
+
+// ackNacker acknowledges or rejects a pending registration. The broker
+// adapter has no reply channel to the publisher, so Ack/Nack are no-ops
+// kept only to satisfy core.AckNacker.
+type ackNacker struct{}
+
+// Ack implements the core.AckNacker interface
+func (ackNacker) Ack(p core.Packet) error { return nil }
+
+// Nack implements the core.AckNacker interface
+func (ackNacker) Nack() error { return nil }
+
+type regEntry struct {
+	registration brokerRegistration
+	ack          ackNacker
+}
+
+// Adapter publishes uplink/downlink packets to a pub/sub broker and
+// consumes application-server registrations from a well-known topic, so
+// that it can be handed to a handler alongside the HTTP adapter: broadcast
+// request/response flows go over HTTP while fan-out event streams go
+// through here.
+type Adapter struct {
+	driver        Driver
+	opts          Options
+	registrations chan regEntry
+	ctx           log.Interface
+
+	closeOnce sync.Once
+	closing   chan struct{}
+}
+
+// NewAdapter dials the broker behind dsn (picked by scheme, e.g.
+// "nats://localhost:4222") and subscribes to opts.RegisterTopic.
+func NewAdapter(dsn string, opts Options, ctx log.Interface) (*Adapter, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || u.Scheme == "" {
+		return nil, errors.New(errors.Structural, fmt.Sprintf("Invalid broker dsn %q", dsn))
+	}
+
+	driversMu.Lock()
+	factory, ok := drivers[u.Scheme]
+	driversMu.Unlock()
+	if !ok {
+		return nil, errors.New(errors.Operational, fmt.Sprintf("Unknown broker driver %q", u.Scheme))
+	}
+
+	driver, err := factory(u)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Adapter{
+		driver:        driver,
+		opts:          opts,
+		registrations: make(chan regEntry),
+		ctx:           ctx,
+		closing:       make(chan struct{}),
+	}
+
+	if err := driver.Subscribe(opts.registerTopic(), a.handleRegistration); err != nil {
+		driver.Close()
+		return nil, errors.New(errors.Operational, err)
+	}
+
+	return a, nil
+}
+
+// handleRegistration turns a message on the register topic into a
+// registration waiting to be consumed through NextRegistration. It is called
+// directly from the driver's subscription callback, so the push onto
+// registrations runs in its own goroutine: blocking here would stall that
+// callback, and with it every other registration on the same subscription,
+// for as long as nobody calls NextRegistration. That goroutine in turn gives
+// up once the adapter is closed, the same escape valve registerAsync uses in
+// the grpc adapter.
+func (a *Adapter) handleRegistration(data []byte) {
+	var reg brokerRegistration
+	rw := readwriter.New(data)
+	rw.Read(func(data []byte) { copy(reg.devEUI[:], data) })
+	rw.TryRead(func(data []byte) error { return reg.recipient.UnmarshalBinary(data) })
+	if err := rw.Err(); err != nil {
+		return
+	}
+	go func() {
+		select {
+		case a.registrations <- regEntry{registration: reg, ack: ackNacker{}}:
+		case <-a.closing:
+		}
+	}()
+}
+
+// Close stops the adapter's underlying driver and unblocks any pending
+// handleRegistration goroutines.
+func (a *Adapter) Close() error {
+	a.closeOnce.Do(func() { close(a.closing) })
+	return a.driver.Close()
+}
+
+// Send implements the core.Adapter interface. With explicit recipients, it
+// publishes to each of their topics; with none, it publishes to the
+// up-topic derived from the packet's DevEUI.
+func (a *Adapter) Send(packet core.Packet, recipients ...core.Recipient) (core.Packet, error) {
+	data, err := packet.MarshalBinary()
+	if err != nil {
+		return nil, errors.New(errors.Structural, err)
+	}
+
+	if len(recipients) == 0 {
+		devEUI, err := packet.(core.Addressable).DevEUI()
+		if err != nil {
+			return nil, errors.New(errors.Structural, err)
+		}
+		if err := a.driver.Publish(a.opts.upTopic(devEUI), data); err != nil {
+			return nil, errors.New(errors.Operational, err)
+		}
+		return nil, nil
+	}
+
+	for _, r := range recipients {
+		topic, ok := r.(brokerRecipient)
+		if !ok {
+			continue
+		}
+		if err := a.driver.Publish(topic.topic, data); err != nil {
+			return nil, errors.New(errors.Operational, err)
+		}
+	}
+	return nil, nil
+}
+
+// NextRegistration implements the core.Adapter interface
+func (a *Adapter) NextRegistration() (core.Registration, core.AckNacker, error) {
+	entry := <-a.registrations
+	return entry.registration, entry.ack, nil
+}
+
+// Recv implements the core.Adapter interface. Inbound uplinks are delivered
+// through whatever topic a caller Subscribe()s to directly on the driver;
+// the adapter itself only pushes.
+func (a *Adapter) Recv() (core.Packet, core.AckNacker, error) {
+	return nil, nil, errors.New(errors.Operational, "Not implemented")
+}
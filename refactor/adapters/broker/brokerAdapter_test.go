@@ -0,0 +1,247 @@
+// Copyright © 2016 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package broker
+
+import (
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	core "github.com/TheThingsNetwork/ttn/refactor"
+	"github.com/TheThingsNetwork/ttn/utils/readwriter"
+	. "github.com/TheThingsNetwork/ttn/utils/testing"
+	"github.com/brocaar/lorawan"
+)
+
+type testPacket struct {
+	devEUI  lorawan.EUI64
+	payload string
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface
+func (p testPacket) MarshalBinary() ([]byte, error) {
+	return []byte(p.payload), nil
+}
+
+// DevEUI implements the core.Addressable interface
+func (p testPacket) DevEUI() (lorawan.EUI64, error) {
+	return p.devEUI, nil
+}
+
+// String implements the core.Packet interface
+func (p testPacket) String() string {
+	return p.payload
+}
+
+// fakePublish records a single Publish call made against a fakeDriver.
+type fakePublish struct {
+	topic string
+	data  []byte
+}
+
+// fakeDriver is an in-memory Driver used in place of a real broker (NATS,
+// ...) so tests can both observe what Send publishes and simulate inbound
+// messages without a live server.
+type fakeDriver struct {
+	mu        sync.Mutex
+	published []fakePublish
+	handlers  map[string]func(data []byte)
+}
+
+func newFakeDriver(u *url.URL) (Driver, error) {
+	return &fakeDriver{handlers: make(map[string]func(data []byte))}, nil
+}
+
+// Publish implements the Driver interface
+func (d *fakeDriver) Publish(topic string, data []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.published = append(d.published, fakePublish{topic, data})
+	return nil
+}
+
+// Subscribe implements the Driver interface
+func (d *fakeDriver) Subscribe(topic string, handler func(data []byte)) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.handlers[topic] = handler
+	return nil
+}
+
+// Close implements the Driver interface
+func (d *fakeDriver) Close() error { return nil }
+
+func (d *fakeDriver) publishes() []fakePublish {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make([]fakePublish, len(d.published))
+	copy(out, d.published)
+	return out
+}
+
+// deliver feeds data to whatever handler Subscribe()d on topic, the same way
+// a real driver would invoke it from its own read loop.
+func (d *fakeDriver) deliver(topic string, data []byte) {
+	d.mu.Lock()
+	handler := d.handlers[topic]
+	d.mu.Unlock()
+	if handler != nil {
+		handler(data)
+	}
+}
+
+func init() {
+	Register("fake", newFakeDriver)
+}
+
+func TestUpTopic(t *testing.T) {
+	devEUI := lorawan.EUI64([8]byte{0, 0, 0, 0, 1, 2, 3, 4})
+
+	Desc(t, "Default template")
+	got := Options{}.upTopic(devEUI)
+	want := "devices/" + devEUI.String() + "/up"
+	if got != want {
+		Ko(t, "Expected topic %s but got %s", want, got)
+		return
+	}
+	Ok(t, "Check default up-topic")
+
+	Desc(t, "Custom template")
+	got = Options{UpTopicTemplate: "ttn/{devEUI}/uplink"}.upTopic(devEUI)
+	want = "ttn/" + devEUI.String() + "/uplink"
+	if got != want {
+		Ko(t, "Expected topic %s but got %s", want, got)
+		return
+	}
+	Ok(t, "Check custom up-topic")
+}
+
+func TestRegisterTopic(t *testing.T) {
+	Desc(t, "Default register topic")
+	if got := (Options{}).registerTopic(); got != "devices/+/register" {
+		Ko(t, "Expected topic %s but got %s", "devices/+/register", got)
+		return
+	}
+	Ok(t, "Check default register-topic")
+}
+
+func TestSendPublish(t *testing.T) {
+	ctx := GetLogger(t, "Adapter")
+	opts := Options{}
+	adapter, err := NewAdapter("fake://test", opts, ctx)
+	if err != nil {
+		panic(err)
+	}
+	driver := adapter.driver.(*fakeDriver)
+	devEUI := lorawan.EUI64([8]byte{0, 0, 0, 0, 1, 2, 3, 4})
+
+	Desc(t, "Broadcasting publishes to the up-topic derived from the packet's DevEUI")
+	if _, err := adapter.Send(testPacket{devEUI: devEUI, payload: "payload"}); err != nil {
+		Ko(t, "Expected no error but got %v", err)
+		return
+	}
+	publishes := driver.publishes()
+	if len(publishes) != 1 || publishes[0].topic != opts.upTopic(devEUI) || string(publishes[0].data) != "payload" {
+		Ko(t, "Expected a publish on %s with payload but got %v", opts.upTopic(devEUI), publishes)
+		return
+	}
+	Ok(t, "Check broadcast publish")
+
+	Desc(t, "Sending to an explicit recipient publishes to its topic")
+	if _, err := adapter.Send(testPacket{devEUI: devEUI, payload: "payload"}, brokerRecipient{topic: "apps/test/down"}); err != nil {
+		Ko(t, "Expected no error but got %v", err)
+		return
+	}
+	publishes = driver.publishes()
+	last := publishes[len(publishes)-1]
+	if last.topic != "apps/test/down" || string(last.data) != "payload" {
+		Ko(t, "Expected a publish on %s with payload but got %v", "apps/test/down", last)
+		return
+	}
+	Ok(t, "Check explicit recipient publish")
+}
+
+func TestRegistrationFlow(t *testing.T) {
+	ctx := GetLogger(t, "Adapter")
+	adapter, err := NewAdapter("fake://test", Options{}, ctx)
+	if err != nil {
+		panic(err)
+	}
+	driver := adapter.driver.(*fakeDriver)
+
+	devEUI := lorawan.EUI64([8]byte{0, 0, 0, 0, 1, 2, 3, 4})
+	recipient := brokerRecipient{topic: "apps/test/down"}
+	recipientData, err := recipient.MarshalBinary()
+	if err != nil {
+		panic(err)
+	}
+	rw := readwriter.New(nil)
+	rw.Write(devEUI[:])
+	rw.Write(recipientData)
+	payload, err := rw.Bytes()
+	if err != nil {
+		panic(err)
+	}
+
+	Desc(t, "Delivering a registration message on the register topic")
+	// handleRegistration dispatches to the registrations channel in its own
+	// goroutine (see the comment on handleRegistration); deliver() itself
+	// must return immediately even though nobody has called
+	// NextRegistration yet.
+	done := make(chan struct{})
+	go func() {
+		driver.deliver(Options{}.registerTopic(), payload)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		Ko(t, "Expected deliver to return without waiting on a NextRegistration call")
+		return
+	}
+
+	ch := make(chan core.Registration, 1)
+	go func() {
+		r, an, err := adapter.NextRegistration()
+		if err != nil {
+			return
+		}
+		an.Ack(nil)
+		ch <- r
+	}()
+
+	select {
+	case r := <-ch:
+		gotDevEUI, err := r.DevEUI()
+		if err != nil || gotDevEUI != devEUI {
+			Ko(t, "Expected registration for %v but got %v (err: %v)", devEUI, gotDevEUI, err)
+			return
+		}
+		gotRecipient, ok := r.Recipient().(brokerRecipient)
+		if !ok || gotRecipient.topic != recipient.topic {
+			Ko(t, "Expected recipient %v but got %v", recipient, gotRecipient)
+			return
+		}
+		Ok(t, "Check registration")
+	case <-time.After(time.Second):
+		Ko(t, "Expected a registration but got none")
+	}
+}
+
+func TestToSubject(t *testing.T) {
+	Desc(t, "Translating a topic with a single-level wildcard")
+	if got := toSubject("devices/+/register"); got != "devices.*.register" {
+		Ko(t, "Expected subject %s but got %s", "devices.*.register", got)
+		return
+	}
+	Ok(t, "Check wildcard translation")
+
+	Desc(t, "Translating a topic with no wildcard")
+	if got := toSubject("devices/aabb/up"); got != "devices.aabb.up" {
+		Ko(t, "Expected subject %s but got %s", "devices.aabb.up", got)
+		return
+	}
+	Ok(t, "Check plain translation")
+}
@@ -0,0 +1,73 @@
+// Copyright © 2016 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package broker
+
+import (
+	"net/url"
+
+	"github.com/TheThingsNetwork/ttn/utils/errors"
+	nats "github.com/nats-io/go-nats"
+)
+
+func init() {
+	Register("nats", newNatsDriver)
+}
+
+// natsDriver is the reference Driver implementation, backed by a NATS
+// connection. NATS subjects use "." as a separator where MQTT/Kafka-style
+// topics use "/", so topics are translated on the way in and out.
+type natsDriver struct {
+	conn *nats.Conn
+}
+
+// newNatsDriver implements the Factory signature for the "nats" scheme,
+// e.g. nats://localhost:4222.
+func newNatsDriver(u *url.URL) (Driver, error) {
+	conn, err := nats.Connect(u.String())
+	if err != nil {
+		return nil, errors.New(errors.Operational, err)
+	}
+	return &natsDriver{conn: conn}, nil
+}
+
+// Publish implements the Driver interface
+func (d *natsDriver) Publish(topic string, data []byte) error {
+	if err := d.conn.Publish(toSubject(topic), data); err != nil {
+		return errors.New(errors.Operational, err)
+	}
+	return nil
+}
+
+// Subscribe implements the Driver interface
+func (d *natsDriver) Subscribe(topic string, handler func(data []byte)) error {
+	_, err := d.conn.Subscribe(toSubject(topic), func(msg *nats.Msg) {
+		handler(msg.Data)
+	})
+	if err != nil {
+		return errors.New(errors.Operational, err)
+	}
+	return nil
+}
+
+// Close implements the Driver interface
+func (d *natsDriver) Close() error {
+	d.conn.Close()
+	return nil
+}
+
+// toSubject turns a "devices/+/register"-style topic into the NATS
+// equivalent "devices.*.register", the only translation the two schemes
+// need.
+func toSubject(topic string) string {
+	subject := []byte(topic)
+	for i, c := range subject {
+		switch c {
+		case '/':
+			subject[i] = '.'
+		case '+':
+			subject[i] = '*'
+		}
+	}
+	return string(subject)
+}
@@ -0,0 +1,317 @@
+// Copyright © 2016 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package grpc
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+
+	"golang.org/x/net/context"
+	ggrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	core "github.com/TheThingsNetwork/ttn/refactor"
+	"github.com/TheThingsNetwork/ttn/utils/errors"
+	"github.com/TheThingsNetwork/ttn/utils/log"
+	"github.com/TheThingsNetwork/ttn/utils/readwriter"
+	"github.com/brocaar/lorawan"
+)
+
+// GrpcRecipient gives enough information to reach a recipient over gRPC
+type GrpcRecipient interface {
+	Host() string
+}
+
+// grpcRecipient materializes recipients manipulated by the grpc adapter
+type grpcRecipient struct {
+	host string
+	tls  *tls.Config
+}
+
+// Host implements the GrpcRecipient interface
+func (r grpcRecipient) Host() string { return r.host }
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface
+func (r grpcRecipient) MarshalBinary() ([]byte, error) {
+	rw := readwriter.New(nil)
+	rw.Write(r.host)
+	return rw.Bytes()
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface. TLS
+// configuration, when needed, is set explicitly by the caller after
+// unmarshaling since it can't round-trip through bytes.
+func (r *grpcRecipient) UnmarshalBinary(data []byte) error {
+	rw := readwriter.New(data)
+	rw.Read(func(data []byte) { r.host = string(data) })
+	return rw.Err()
+}
+
+// grpcRegistration couples a freshly discovered recipient with the device it
+// was discovered for
+type grpcRegistration struct {
+	recipient grpcRecipient
+	devEUI    lorawan.EUI64
+}
+
+// Recipient implements the core.Registration interface
+func (r grpcRegistration) Recipient() core.Recipient { return r.recipient }
+
+// DevEUI implements the core.Registration interface
+func (r grpcRegistration) DevEUI() (lorawan.EUI64, error) { return r.devEUI, nil }
+
+// ackNacker acknowledges or rejects a pending registration
+type ackNacker struct {
+	result chan error
+}
+
+// Ack implements the core.AckNacker interface
+func (an ackNacker) Ack(p core.Packet) error {
+	an.result <- nil
+	return nil
+}
+
+// Nack implements the core.AckNacker interface
+func (an ackNacker) Nack() error {
+	an.result <- errors.New(errors.Behavioural, "Rejected")
+	return nil
+}
+
+type regEntry struct {
+	registration grpcRegistration
+	ack          ackNacker
+}
+
+// connPool dials recipients lazily and reuses the connection across calls,
+// keyed by address, so repeated sends to the same recipient don't pay the
+// handshake cost again.
+type connPool struct {
+	sync.Mutex
+	conns map[string]*ggrpc.ClientConn
+}
+
+func (p *connPool) get(r grpcRecipient) (*ggrpc.ClientConn, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	if conn, ok := p.conns[r.host]; ok {
+		return conn, nil
+	}
+
+	var opts []ggrpc.DialOption
+	if r.tls != nil {
+		opts = append(opts, ggrpc.WithTransportCredentials(credentials.NewTLS(r.tls)))
+	} else {
+		opts = append(opts, ggrpc.WithInsecure())
+	}
+
+	conn, err := ggrpc.Dial(r.host, opts...)
+	if err != nil {
+		return nil, errors.New(errors.Operational, err)
+	}
+	p.conns[r.host] = conn
+	return conn, nil
+}
+
+func (p *connPool) close() error {
+	p.Lock()
+	defer p.Unlock()
+	for _, conn := range p.conns {
+		conn.Close()
+	}
+	return nil
+}
+
+// Adapter delivers packets and registrations over gRPC. It implements the
+// same core.Adapter contract as the HTTP adapter: broadcasting to every
+// known recipient, returning the first success, and surfacing the winning
+// recipient through NextRegistration so upstream handlers can swap between
+// adapters transparently.
+type Adapter struct {
+	recipients    []core.Recipient
+	registrations chan regEntry
+	pool          *connPool
+	server        *ggrpc.Server
+	listener      net.Listener
+	ctx           log.Interface
+
+	closeOnce sync.Once
+	closing   chan struct{}
+}
+
+// NewAdapter constructs a grpc adapter listening on the given port, seeded
+// with a static list of recipients used as the broadcast target whenever
+// Send() is called without any explicit recipient.
+func NewAdapter(port uint, recipients []core.Recipient, ctx log.Interface) (*Adapter, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, errors.New(errors.Operational, err)
+	}
+
+	a := &Adapter{
+		recipients:    recipients,
+		registrations: make(chan regEntry),
+		pool:          &connPool{conns: make(map[string]*ggrpc.ClientConn)},
+		server:        ggrpc.NewServer(),
+		listener:      listener,
+		ctx:           ctx,
+		closing:       make(chan struct{}),
+	}
+
+	RegisterAdapterServer(a.server, grpcServer{a})
+	go a.server.Serve(listener)
+
+	return a, nil
+}
+
+// Send implements the core.Adapter interface, broadcasting to every
+// recipient (or the explicit ones given) and keeping the first success.
+func (a *Adapter) Send(packet core.Packet, recipients ...core.Recipient) (core.Packet, error) {
+	data, err := packet.MarshalBinary()
+	if err != nil {
+		return nil, errors.New(errors.Structural, err)
+	}
+
+	broadcast := len(recipients) == 0
+	if broadcast {
+		recipients = a.recipients
+	}
+
+	type result struct {
+		payload []byte
+		from    grpcRecipient
+	}
+
+	results := make(chan result, len(recipients))
+
+	var wg sync.WaitGroup
+	for _, r := range recipients {
+		grpcR, ok := r.(grpcRecipient)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(r grpcRecipient) {
+			defer wg.Done()
+			payload, err := a.sendOne(r, data)
+			if err != nil {
+				return
+			}
+			results <- result{payload, r}
+		}(grpcR)
+	}
+	// Closing results once every attempt has settled turns "nobody accepted
+	// the packet" into an observable event instead of leaving the caller
+	// blocked forever below.
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	res, ok := <-results
+	if !ok {
+		return nil, errors.New(errors.Operational, "No recipient accepted the packet")
+	}
+	if broadcast {
+		if devEUI, err := packet.(core.Addressable).DevEUI(); err == nil {
+			a.registerAsync(grpcRegistration{recipient: res.from, devEUI: devEUI})
+		}
+	}
+	return rawPacket(res.payload), nil
+}
+
+// sendOne delivers data to a single recipient, dialing (or reusing) its
+// connection from the pool.
+func (a *Adapter) sendOne(r grpcRecipient, data []byte) ([]byte, error) {
+	conn, err := a.pool.get(r)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := NewAdapterClient(conn).Send(context.Background(), &Packet{Payload: data})
+	if err != nil {
+		return nil, errors.New(errors.Operational, err)
+	}
+	return resp.Payload, nil
+}
+
+// registerAsync pushes a freshly discovered registration without blocking
+// the send path on a consumer being ready. It gives up once the adapter is
+// closed, so it never outlives the Adapter that spawned it.
+func (a *Adapter) registerAsync(r grpcRegistration) {
+	go func() {
+		select {
+		case a.registrations <- regEntry{registration: r, ack: ackNacker{result: make(chan error, 1)}}:
+		case <-a.closing:
+		}
+	}()
+}
+
+// Close stops the adapter's server, releases its pooled connections, and
+// unblocks any pending registerAsync goroutines.
+func (a *Adapter) Close() error {
+	a.closeOnce.Do(func() { close(a.closing) })
+	a.server.Stop()
+	return a.pool.close()
+}
+
+// NextRegistration implements the core.Adapter interface
+func (a *Adapter) NextRegistration() (core.Registration, core.AckNacker, error) {
+	entry := <-a.registrations
+	return entry.registration, entry.ack, nil
+}
+
+// Recv implements the core.Adapter interface. Inbound packets arrive
+// through the Send RPC handler below instead.
+func (a *Adapter) Recv() (core.Packet, core.AckNacker, error) {
+	return nil, nil, errors.New(errors.Operational, "Not implemented")
+}
+
+// grpcServer is the AdapterServer-facing side of an Adapter: it has its own
+// Send/Register methods so they don't collide with Adapter's core.Adapter
+// Send method, which has a different signature.
+type grpcServer struct {
+	*Adapter
+}
+
+// Send implements the AdapterServer interface: it is the RPC handler for
+// inbound deliveries made to this adapter by a peer.
+func (s grpcServer) Send(ctx context.Context, in *Packet) (*Packet, error) {
+	return &Packet{Payload: in.Payload}, nil
+}
+
+// Register implements the AdapterServer interface: it turns an inbound
+// Register RPC into a registration consumed through NextRegistration.
+func (s grpcServer) Register(ctx context.Context, in *Registration) (*Ack, error) {
+	var devEUI lorawan.EUI64
+	copy(devEUI[:], in.DevEUI)
+
+	recipient := grpcRecipient{}
+	if err := recipient.UnmarshalBinary(in.Recipient); err != nil {
+		return &Ack{Accepted: false, Reason: err.Error()}, nil
+	}
+
+	result := make(chan error, 1)
+	s.registrations <- regEntry{
+		registration: grpcRegistration{recipient: recipient, devEUI: devEUI},
+		ack:          ackNacker{result: result},
+	}
+
+	if err := <-result; err != nil {
+		return &Ack{Accepted: false, Reason: err.Error()}, nil
+	}
+	return &Ack{Accepted: true}, nil
+}
+
+// rawPacket is the minimal core.Packet implementation used to surface a raw
+// gRPC response payload back to the caller of Send.
+type rawPacket []byte
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface
+func (p rawPacket) MarshalBinary() ([]byte, error) { return p, nil }
+
+// String implements the core.Packet interface
+func (p rawPacket) String() string { return string(p) }
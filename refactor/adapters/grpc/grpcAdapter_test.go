@@ -0,0 +1,151 @@
+// Copyright © 2016 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package grpc
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	ggrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	core "github.com/TheThingsNetwork/ttn/refactor"
+	. "github.com/TheThingsNetwork/ttn/utils/testing"
+	"github.com/brocaar/lorawan"
+)
+
+type testPacket struct {
+	devEUI  lorawan.EUI64
+	payload string
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface
+func (p testPacket) MarshalBinary() ([]byte, error) {
+	return []byte(p.payload), nil
+}
+
+// DevEUI implements the core.Addressable interface
+func (p testPacket) DevEUI() (lorawan.EUI64, error) {
+	return p.devEUI, nil
+}
+
+// String implements the core.Packet interface
+func (p testPacket) String() string {
+	return p.payload
+}
+
+// mockServer plays the AlwaysAccept / AlwaysReject roles on the wire, the
+// same way http_test.go's genMockServer does for the HTTP adapter.
+type mockServer struct {
+	Behavior string
+}
+
+func (s mockServer) Send(ctx context.Context, in *Packet) (*Packet, error) {
+	switch s.Behavior {
+	case "AlwaysReject":
+		return nil, ggrpc.Errorf(codes.PermissionDenied, "Rejected")
+	default:
+		return &Packet{Payload: in.Payload}, nil
+	}
+}
+
+func (s mockServer) Register(ctx context.Context, in *Registration) (*Ack, error) {
+	return &Ack{Accepted: true}, nil
+}
+
+// genMockServer starts an in-process gRPC server on port playing behavior,
+// mirroring http_test.go's genMockServer.
+func genMockServer(port uint, behavior string) {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		panic(err)
+	}
+	s := ggrpc.NewServer()
+	RegisterAdapterServer(s, mockServer{Behavior: behavior})
+	go s.Serve(listener)
+}
+
+func TestSend(t *testing.T) {
+	genMockServer(3030, "AlwaysReject")
+	genMockServer(3031, "AlwaysAccept")
+	genMockServer(3032, "AlwaysReject")
+	genMockServer(3033, "AlwaysReject")
+
+	recipients := []core.Recipient{
+		grpcRecipient{host: "0.0.0.0:3030"},
+		grpcRecipient{host: "0.0.0.0:3031"},
+		grpcRecipient{host: "0.0.0.0:3032"},
+		grpcRecipient{host: "0.0.0.0:3033"},
+	}
+
+	ctx := GetLogger(t, "Adapter")
+	adapter, err := NewAdapter(3029, recipients, ctx)
+	if err != nil {
+		panic(err)
+	}
+
+	Desc(t, "Sending a packet to a single accepting recipient")
+	resp, err := adapter.Send(testPacket{
+		devEUI:  lorawan.EUI64([8]byte{0, 0, 0, 0, 1, 2, 3, 4}),
+		payload: "payload",
+	}, recipients[1])
+	if err != nil {
+		Ko(t, "Expected no error but got %v", err)
+		return
+	}
+	if resp.String() != "payload" {
+		Ko(t, "Expected payload %s but got %s", "payload", resp.String())
+		return
+	}
+	Ok(t, "Check payload")
+
+	Desc(t, "Sending a packet to only rejecting recipients")
+	if _, err := adapter.Send(testPacket{
+		devEUI:  lorawan.EUI64([8]byte{0, 0, 0, 0, 1, 2, 3, 4}),
+		payload: "payload",
+	}, recipients[0], recipients[2], recipients[3]); err == nil {
+		Ko(t, "Expected an error but got none")
+		return
+	}
+	Ok(t, "Check all-rejected error")
+
+	Desc(t, "Broadcasting across mixed accept/reject recipients should surface a registration for the sole acceptor")
+	ch := make(chan core.Registration)
+	go func() {
+		r, an, err := adapter.NextRegistration()
+		if err != nil {
+			return
+		}
+		an.Ack(nil)
+		ch <- r
+	}()
+
+	if _, err := adapter.Send(testPacket{
+		devEUI:  lorawan.EUI64([8]byte{0, 0, 0, 0, 1, 2, 3, 4}),
+		payload: "payload",
+	}); err != nil {
+		Ko(t, "Expected no error but got %v", err)
+		return
+	}
+
+	select {
+	case r := <-ch:
+		devEUI, _ := r.DevEUI()
+		if devEUI != (lorawan.EUI64{0, 0, 0, 0, 1, 2, 3, 4}) {
+			Ko(t, "Got a registration for the wrong device %v", devEUI)
+			return
+		}
+		recipient, ok := r.Recipient().(grpcRecipient)
+		if !ok || recipient.host != "0.0.0.0:3031" {
+			Ko(t, "Expected a registration for the accepting recipient but got %v", recipient)
+			return
+		}
+		Ok(t, "Check registration")
+	case <-time.After(time.Second):
+		Ko(t, "Expected a registration but got none")
+	}
+}
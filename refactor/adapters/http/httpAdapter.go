@@ -0,0 +1,356 @@
+// Copyright © 2016 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	core "github.com/TheThingsNetwork/ttn/refactor"
+	"github.com/TheThingsNetwork/ttn/utils/errors"
+	"github.com/TheThingsNetwork/ttn/utils/log"
+	"github.com/TheThingsNetwork/ttn/utils/readwriter"
+	"github.com/brocaar/lorawan"
+)
+
+// HttpRecipient gives enough information to reach a recipient over HTTP
+type HttpRecipient interface {
+	Url() string
+	Method() string
+	Codec() string
+}
+
+// httpRecipient materializes recipients manipulated by the http adapter
+type httpRecipient struct {
+	url    string
+	method string
+	codec  Codec
+}
+
+// Url implements the HttpRecipient interface
+func (r httpRecipient) Url() string { return r.url }
+
+// Method implements the HttpRecipient interface
+func (r httpRecipient) Method() string { return r.method }
+
+// Codec implements the HttpRecipient interface. Recipients created without
+// an explicit codec keep talking the historical raw binary format.
+func (r httpRecipient) Codec() string {
+	if r.codec == "" {
+		return string(CodecBinary)
+	}
+	return string(r.codec)
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface
+func (r httpRecipient) MarshalBinary() ([]byte, error) {
+	rw := readwriter.New(nil)
+	rw.Write(r.url)
+	rw.Write(r.method)
+	rw.Write(r.Codec())
+	return rw.Bytes()
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface
+func (r *httpRecipient) UnmarshalBinary(data []byte) error {
+	rw := readwriter.New(data)
+	rw.Read(func(data []byte) { r.url = string(data) })
+	rw.Read(func(data []byte) { r.method = string(data) })
+	rw.TryRead(func(data []byte) error {
+		r.codec = Codec(data)
+		return nil
+	})
+	return rw.Err()
+}
+
+// httpRegistration couples a freshly discovered recipient with the device it
+// was discovered for
+type httpRegistration struct {
+	recipient httpRecipient
+	devEUI    lorawan.EUI64
+}
+
+// Recipient implements the core.Registration interface
+func (r httpRegistration) Recipient() core.Recipient { return r.recipient }
+
+// DevEUI implements the core.Registration interface
+func (r httpRegistration) DevEUI() (lorawan.EUI64, error) { return r.devEUI, nil }
+
+// ackNacker acknowledges or rejects a pending registration
+type ackNacker struct {
+	result chan error
+}
+
+// Ack implements the core.AckNacker interface
+func (an ackNacker) Ack(p core.Packet) error {
+	an.result <- nil
+	return nil
+}
+
+// Nack implements the core.AckNacker interface
+func (an ackNacker) Nack() error {
+	an.result <- errors.New(errors.Behavioural, "Rejected")
+	return nil
+}
+
+// regEntry is what flows through the registrations channel, waiting to be
+// picked up by NextRegistration
+type regEntry struct {
+	registration httpRegistration
+	ack          ackNacker
+}
+
+// Adapter sends and receives packets over HTTP. The recipient that accepts a
+// broadcast is surfaced as a registration through NextRegistration; the
+// adapter itself does not keep it, and later broadcasts fan out to the
+// static recipient list again.
+type Adapter struct {
+	recipients    []core.Recipient
+	registrations chan regEntry
+	client        http.Client
+	listener      net.Listener
+	ctx           log.Interface
+
+	mu     sync.Mutex // guards cancel and timer below
+	cancel chan struct{}
+	timer  *time.Timer
+}
+
+// NewAdapter constructs an http adapter listening on the given port, seeded
+// with a static list of recipients used as the broadcast target whenever
+// Send() is called without any explicit recipient.
+func NewAdapter(port uint, recipients []core.Recipient, ctx log.Interface) (*Adapter, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, errors.New(errors.Operational, err)
+	}
+
+	a := &Adapter{
+		recipients:    recipients,
+		registrations: make(chan regEntry),
+		listener:      listener,
+		ctx:           ctx,
+		cancel:        make(chan struct{}),
+	}
+
+	go http.Serve(listener, a)
+
+	return a, nil
+}
+
+// ServeHTTP implements the http.Handler interface. Inbound delivery isn't
+// implemented yet in this chunk (see Recv below); every request is rejected.
+func (a *Adapter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.WriteHeader(http.StatusNotFound)
+}
+
+// Send implements the core.Adapter interface. It is a thin wrapper around
+// SendContext using a background context, preserved for callers that don't
+// need to bound how long the send may take.
+func (a *Adapter) Send(packet core.Packet, recipients ...core.Recipient) (core.Packet, error) {
+	return a.SendContext(context.Background(), packet, recipients...)
+}
+
+// SendContext behaves like Send but stops waiting on the fan-out as soon as
+// ctx is done or the adapter's own deadline (see SetDeadline) fires,
+// whichever comes first.
+func (a *Adapter) SendContext(ctx context.Context, packet core.Packet, recipients ...core.Recipient) (core.Packet, error) {
+	data, err := packet.MarshalBinary()
+	if err != nil {
+		return nil, errors.New(errors.Structural, err)
+	}
+
+	broadcast := len(recipients) == 0
+	if broadcast {
+		recipients = a.recipients
+	}
+
+	type result struct {
+		payload []byte
+		from    httpRecipient
+	}
+
+	cancel := a.deadlineChan()
+
+	// http.Client only honors ctx, not the adapter's own deadline, so derive
+	// a context that's cancelled the moment either one fires and hand that
+	// to sendOne instead of ctx directly. Otherwise a fan-out goroutine stuck
+	// talking to a slow/unresponsive recipient would outlive the deadline.
+	callCtx, cancelCall := context.WithCancel(ctx)
+	defer cancelCall()
+	go func() {
+		select {
+		case <-cancel:
+			cancelCall()
+		case <-callCtx.Done():
+		}
+	}()
+
+	results := make(chan result, len(recipients))
+
+	var wg sync.WaitGroup
+	for _, r := range recipients {
+		httpR, ok := r.(httpRecipient)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(r httpRecipient) {
+			defer wg.Done()
+			payload, err := a.sendOne(callCtx, data, r)
+			if err != nil {
+				return
+			}
+			select {
+			case results <- result{payload, r}:
+			case <-ctx.Done():
+			case <-cancel:
+			}
+		}(httpR)
+	}
+	// Closing results once every attempt has settled turns "nobody accepted
+	// the packet" into an observable event instead of leaving the caller
+	// blocked forever below.
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	select {
+	case res, ok := <-results:
+		if !ok {
+			return nil, errors.New(errors.Operational, "No recipient accepted the packet")
+		}
+		if broadcast {
+			if devEUI, err := packet.(core.Addressable).DevEUI(); err == nil {
+				a.registerAsync(ctx, cancel, httpRegistration{recipient: res.from, devEUI: devEUI})
+			}
+		}
+		return rawPacket(res.payload), nil
+	case <-ctx.Done():
+		return nil, errors.New(errors.Operational, ctx.Err())
+	case <-cancel:
+		return nil, errors.New(errors.Operational, "Adapter deadline exceeded")
+	}
+}
+
+// sendOne delivers data to a single recipient over HTTP, honoring ctx's
+// deadline / cancellation on the underlying request. The wire format is
+// whichever codec the recipient asks for (see httpCodec.go); data is always
+// the packet's raw MarshalBinary output.
+func (a *Adapter) sendOne(ctx context.Context, data []byte, r httpRecipient) ([]byte, error) {
+	codec := Codec(r.Codec())
+	body, err := marshalCodec(codec, data)
+	if err != nil {
+		return nil, errors.New(errors.Structural, err)
+	}
+
+	req, err := http.NewRequest(r.method, fmt.Sprintf("http://%s", r.url), bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.New(errors.Structural, err)
+	}
+	req.Header.Set("Content-Type", string(codec))
+	req.Header.Set("Accept", string(codec))
+
+	resp, err := a.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.New(errors.Operational, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(errors.Operational, fmt.Sprintf("Unexpected response %s", resp.Status))
+	}
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.New(errors.Operational, err)
+	}
+
+	return unmarshalCodec(resp.Header.Get("Content-Type"), buf)
+}
+
+// registerAsync pushes a freshly discovered registration without blocking
+// the send path on a consumer being ready. It gives up once ctx is done or
+// cancel fires, so it never outlives the SendContext call that spawned it.
+func (a *Adapter) registerAsync(ctx context.Context, cancel <-chan struct{}, r httpRegistration) {
+	go func() {
+		select {
+		case a.registrations <- regEntry{registration: r, ack: ackNacker{result: make(chan error, 1)}}:
+		case <-ctx.Done():
+		case <-cancel:
+		}
+	}()
+}
+
+// NextRegistration implements the core.Adapter interface
+func (a *Adapter) NextRegistration() (core.Registration, core.AckNacker, error) {
+	entry := <-a.registrations
+	return entry.registration, entry.ack, nil
+}
+
+// Recv implements the core.Adapter interface. Not implemented yet: this
+// chunk only wires up the outbound fan-out (Send/SendContext); ServeHTTP
+// rejects every inbound request rather than feeding them here.
+func (a *Adapter) Recv() (core.Packet, core.AckNacker, error) {
+	return nil, nil, errors.New(errors.Operational, "Not implemented")
+}
+
+// SetDeadline arms (or disarms) the deadline applied to every in-flight and
+// future SendContext call, following the same cancel-channel pattern used by
+// netstack's gonet adapter: a timer closes a dedicated channel that sends
+// select on. Re-arming it replaces the timer, and replaces the channel only
+// once it has been closed, so in-flight calls keep waiting on the channel
+// the new timer closes and nothing leaks. A zero time.Time clears the
+// deadline.
+func (a *Adapter) SetDeadline(t time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.timer != nil && !a.timer.Stop() {
+		a.cancel = make(chan struct{})
+	}
+	a.timer = nil
+
+	select {
+	case <-a.cancel:
+		a.cancel = make(chan struct{})
+	default:
+	}
+	cancel := a.cancel
+
+	if t.IsZero() {
+		return
+	}
+
+	if d := time.Until(t); d > 0 {
+		a.timer = time.AfterFunc(d, func() { close(cancel) })
+	} else {
+		close(cancel)
+	}
+}
+
+// deadlineChan returns the channel currently associated with the adapter's
+// deadline, closed once that deadline elapses (or never, if none is set).
+func (a *Adapter) deadlineChan() chan struct{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.cancel
+}
+
+// rawPacket is the minimal core.Packet implementation used to surface a raw
+// HTTP response body back to the caller of Send / SendContext.
+type rawPacket []byte
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface
+func (p rawPacket) MarshalBinary() ([]byte, error) { return p, nil }
+
+// String implements the core.Packet interface
+func (p rawPacket) String() string { return string(p) }
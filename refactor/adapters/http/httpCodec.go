@@ -0,0 +1,70 @@
+// Copyright © 2016 The Things Network
+// Use of this source code is governed by the MIT license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"encoding/json"
+
+	"github.com/fxamacker/cbor"
+)
+
+// Codec identifies the wire format used between the http adapter and a
+// recipient, as both a Content-Type and an Accept header value.
+type Codec string
+
+const (
+	// CodecBinary sends the packet's raw MarshalBinary output as-is. This is
+	// the historical, and still the default, format.
+	CodecBinary Codec = "application/octet-stream"
+
+	// CodecJSON wraps the marshaled packet in a JSON envelope, for operators
+	// integrating TTN with JSON-speaking application servers.
+	CodecJSON Codec = "application/json"
+
+	// CodecCBOR wraps the marshaled packet in a CBOR envelope, a more
+	// compact alternative to JSON for the same integrations.
+	CodecCBOR Codec = "application/cbor"
+)
+
+// envelope carries a marshaled packet for the codecs that can't ship raw
+// bytes as their top-level document.
+type envelope struct {
+	Payload []byte `json:"payload" cbor:"payload"`
+}
+
+// marshalCodec encodes a packet's raw bytes for the wire, according to
+// codec. Unknown codecs are treated as CodecBinary.
+func marshalCodec(codec Codec, data []byte) ([]byte, error) {
+	switch codec {
+	case CodecJSON:
+		return json.Marshal(envelope{Payload: data})
+	case CodecCBOR:
+		return cbor.Marshal(envelope{Payload: data}, cbor.EncOptions{})
+	default:
+		return data, nil
+	}
+}
+
+// unmarshalCodec decodes a response body back to the packet's raw bytes,
+// dispatching on the Content-Type it was served with. Unknown or empty
+// content types are treated as CodecBinary, so plain recipients keep
+// working unchanged.
+func unmarshalCodec(contentType string, body []byte) ([]byte, error) {
+	switch Codec(contentType) {
+	case CodecJSON:
+		var e envelope
+		if err := json.Unmarshal(body, &e); err != nil {
+			return nil, err
+		}
+		return e.Payload, nil
+	case CodecCBOR:
+		var e envelope
+		if err := cbor.Unmarshal(body, &e); err != nil {
+			return nil, err
+		}
+		return e.Payload, nil
+	default:
+		return body, nil
+	}
+}
@@ -4,6 +4,7 @@
 package http
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"reflect"
@@ -162,6 +163,130 @@ func TestSend(t *testing.T) {
 	}
 }
 
+func TestSendCodecs(t *testing.T) {
+	recipients := []testRecipient{
+		testRecipient{
+			httpRecipient: httpRecipient{
+				url:    "0.0.0.0:3040",
+				method: "POST",
+				codec:  CodecJSON,
+			},
+			Behavior: "AlwaysAccept",
+		},
+		testRecipient{
+			httpRecipient: httpRecipient{
+				url:    "0.0.0.0:3041",
+				method: "POST",
+				codec:  CodecCBOR,
+			},
+			Behavior: "AlwaysAccept",
+		},
+	}
+
+	ctx := GetLogger(t, "Adapter")
+	adapter, err := NewAdapter(3042, toHttpRecipient(recipients), ctx)
+	if err != nil {
+		panic(err)
+	}
+	var servers []chan string
+	for _, r := range recipients {
+		servers = append(servers, genMockServer(r))
+	}
+
+	for _, r := range recipients {
+		Desc(t, "Sending a packet to a %s recipient", r.Codec())
+		_, err := adapter.Send(testPacket{
+			devEUI:  lorawan.EUI64([8]byte{0, 0, 0, 0, 1, 2, 3, 4}),
+			payload: "payload",
+		}, r.httpRecipient)
+		if err != nil {
+			Ko(t, "Expected no error but got %v", err)
+			return
+		}
+		Ok(t, "Check send")
+	}
+
+	payloads := getPayloads(servers)
+	checkPayloads(t, "payload", payloads)
+}
+
+func TestSendContextCancelled(t *testing.T) {
+	ctx := GetLogger(t, "Adapter")
+	recipient := testRecipient{
+		httpRecipient: httpRecipient{
+			url:    "0.0.0.0:3021",
+			method: "POST",
+		},
+		Behavior: "AlwaysAccept",
+	}
+	adapter, err := NewAdapter(3020, toHttpRecipient([]testRecipient{recipient}), ctx)
+	if err != nil {
+		panic(err)
+	}
+	genMockServer(recipient)
+
+	Desc(t, "Sending with an already-cancelled context")
+	cctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := adapter.SendContext(cctx, testPacket{
+			devEUI:  lorawan.EUI64([8]byte{0, 0, 0, 0, 1, 2, 3, 4}),
+			payload: "payload",
+		})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			Ko(t, "Expected an error but got none")
+			return
+		}
+		Ok(t, "Check context cancellation error")
+	case <-time.After(time.Second):
+		Ko(t, "Expected SendContext to return as soon as the context was cancelled")
+	}
+}
+
+func TestSendDeadlineExceeded(t *testing.T) {
+	ctx := GetLogger(t, "Adapter")
+	recipient := testRecipient{
+		httpRecipient: httpRecipient{
+			url:    "0.0.0.0:3023",
+			method: "POST",
+		},
+		Behavior: "AlwaysAccept",
+	}
+	adapter, err := NewAdapter(3022, toHttpRecipient([]testRecipient{recipient}), ctx)
+	if err != nil {
+		panic(err)
+	}
+	genMockServer(recipient)
+
+	Desc(t, "Sending after the adapter's deadline has already elapsed")
+	adapter.SetDeadline(time.Now().Add(-time.Second))
+	done := make(chan error, 1)
+	go func() {
+		_, err := adapter.Send(testPacket{
+			devEUI:  lorawan.EUI64([8]byte{0, 0, 0, 0, 1, 2, 3, 4}),
+			payload: "payload",
+		})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			Ko(t, "Expected an error but got none")
+			return
+		}
+		Ok(t, "Check deadline exceeded error")
+	case <-time.After(time.Second):
+		Ko(t, "Expected Send to return as soon as the deadline elapsed")
+	}
+}
+
 // Convert testRecipient to core.Recipient
 func toHttpRecipient(recipients []testRecipient) []core.Recipient {
 	var https []core.Recipient
@@ -209,29 +334,43 @@ func getRegistrations(adapter *Adapter, want []testRegistration) []core.Registra
 func genMockServer(recipient core.Recipient) chan string {
 	chresp := make(chan string)
 	serveMux := http.NewServeMux()
+	codec := recipient.(testRecipient).Codec()
 	serveMux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
-		if req.Header.Get("Content-Type") != "application/octet-stream" {
+		if req.Header.Get("Content-Type") != codec {
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write(nil)
 			return
 		}
 
-		buf := make([]byte, req.ContentLength)
-		n, err := req.Body.Read(buf)
+		raw := make([]byte, req.ContentLength)
+		n, err := req.Body.Read(raw)
 		if err != nil && err != io.EOF {
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write(nil)
 			return
 		}
+		buf, err := unmarshalCodec(codec, raw[:n])
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(nil)
+			return
+		}
+		n = len(buf)
 
 		switch recipient.(testRecipient).Behavior {
 		case "AlwaysReject":
 			w.WriteHeader(http.StatusNotFound)
 			w.Write(nil)
 		case "AlwaysAccept":
-			w.Header().Add("Content-Type", "application/octet-stream")
+			body, err := marshalCodec(Codec(codec), buf[:n]) // TODO, should respond another packet, not the same
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write(nil)
+				return
+			}
+			w.Header().Add("Content-Type", codec)
 			w.WriteHeader(http.StatusOK)
-			w.Write(buf[:n]) // TODO, should respond another packet, not the same
+			w.Write(body)
 		}
 		go func() { chresp <- string(buf[:n]) }()
 	})